@@ -1,31 +1,141 @@
 package ldapool
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/url"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/go-ldap/ldap/v3"
 )
 
+// TLSConfig controls certificate verification for LDAPS and StartTLS connections.
+type TLSConfig struct {
+	SkipCertVerification bool
+	CertFile             string
+	KeyFile              string
+	CaFile               string
+}
+
 // LdapConfig ldap conn config
 type LdapConfig struct {
 	Url     string
 	BaseDN  string
 	MaxOpen int
+
+	// BindDN and BindPassword are used to authenticate every freshly dialed
+	// connection. If BindDN is empty the connection is left anonymous.
+	BindDN       string
+	BindPassword string
+
+	// UseTLS dials the connection over LDAPS, while StartTLS upgrades a plain
+	// connection after dialing. TLSConfig applies to either mode.
+	UseTLS    bool
+	StartTLS  bool
+	TLSConfig TLSConfig
+
+	// HealthCheckInterval, when non-zero, makes a background goroutine sweep
+	// idle connections that have sat unused longer than the interval,
+	// evicting any that fail a cheap WhoAmI probe.
+	HealthCheckInterval time.Duration
+
+	// MaxLifetime, when non-zero, caps how long a connection may live
+	// (counted from dial) before it is closed instead of being reused.
+	MaxLifetime time.Duration
+
+	// CloseOnResultCodes lists LDAP result codes (e.g.
+	// ldap.LDAPResultTimeLimitExceeded, ldap.ErrorNetwork) that mark a
+	// connection as unhealthy; PutConnErr closes such connections instead of
+	// returning them to the pool.
+	CloseOnResultCodes []uint16
+
+	// WaitTimeout bounds how long GetConn/Open/Do wait for a connection to
+	// free up once MaxOpen is reached. Zero means wait indefinitely (subject
+	// only to the caller's context).
+	WaitTimeout time.Duration
+
+	// PoolFactory dials a new connection for the pool, defaulting to
+	// initLDAPConn (plain/TLS dial plus StartTLS/Bind as configured above).
+	// Override it to inject a fake ldap.Client in unit tests, wrap the
+	// client for tracing/metrics, or swap in an alternative dialer (mTLS,
+	// SOCKS, LDAPI over a unix socket).
+	PoolFactory PoolFactory
+
+	// Observer, if set, is notified of pool activity so operators can wire
+	// it into their metrics stack.
+	Observer Observer
+}
+
+// PoolFactory dials (and fully authenticates) a new connection for the pool.
+type PoolFactory func(cfg LdapConfig) (ldap.Client, error)
+
+// Observer receives pool lifecycle events. Implementations must be safe for
+// concurrent use and should not block, since they run on the request path.
+type Observer interface {
+	// OnAcquire is called when a connection is handed out, either from the
+	// idle pool or freshly dialed.
+	OnAcquire(conn ldap.Client)
+	// OnRelease is called when a connection is returned to the pool,
+	// whether or not it ends up reused.
+	OnRelease(conn ldap.Client)
+	// OnDial is called after every dial attempt, successful or not.
+	OnDial(conn ldap.Client, err error)
+}
+
+// PoolStats reports a snapshot of pool activity, in the style of
+// sql.DBStats.
+type PoolStats struct {
+	MaxOpen      int
+	InUse        int
+	Idle         int
+	WaitCount    int64
+	WaitDuration time.Duration
+	Timeouts     int64
+	Reconnects   int64
+}
+
+// pooledConn wraps an ldap.Client with the bookkeeping the pool needs to
+// judge liveness: when it was dialed, when it was last handed back, and the
+// result code of the last operation reported against it.
+type pooledConn struct {
+	conn           ldap.Client
+	createdAt      time.Time
+	lastUsed       time.Time
+	lastResultCode uint16
+}
+
+// connResult is what a waiter parked in reqConns receives: either a
+// connection handed off by putConnection, or the error from redialing one
+// when the connection it was about to receive turned out unhealthy.
+type connResult struct {
+	conn ldap.Client
+	err  error
 }
 
 // Connection pool
 type ldapConnPool struct {
 	mu       sync.Mutex
-	conns    []*ldap.Conn
-	reqConns map[uint64]chan *ldap.Conn
-	openConn int
+	conns    []*pooledConn
+	meta     map[ldap.Client]*pooledConn
+	reqConns map[uint64]chan connResult
+	inUse    int // connections currently checked out
 	maxOpen  int
 	DsName   string
 	config   LdapConfig
+	stopOnce sync.Once
+	stopCh   chan struct{}
+
+	waitCount    int64
+	waitDuration time.Duration
+	timeouts     int64
+	reconnects   int64
 }
 
 type LdapPoolManager struct {
@@ -48,11 +158,17 @@ func NewLdapPoolManager(conf LdapConfig) (*LdapPoolManager, error) {
 	return manager, nil
 }
 
-func (manager *LdapPoolManager) Open() (*ldap.Conn, error) {
+func (manager *LdapPoolManager) Open() (ldap.Client, error) {
+	return manager.OpenCtx(context.Background())
+}
+
+// OpenCtx is the context-aware variant of Open: it fails with ctx.Err() if
+// ctx is cancelled, or times out, before a connection becomes available.
+func (manager *LdapPoolManager) OpenCtx(ctx context.Context) (ldap.Client, error) {
 	if !manager.ldapInit {
 		return nil, fmt.Errorf("LDAP connection is not initialized")
 	}
-	return manager.GetConn()
+	return manager.GetConnCtx(ctx)
 }
 
 // Initialize connection
@@ -65,32 +181,40 @@ func (manager *LdapPoolManager) initLDAP() error {
 		manager.ldapInit = true
 	})
 
-	ldapConn, err := ldap.DialURL(manager.config.Url, ldap.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}))
+	ldapConn, err := dial(manager.config)
 	if err != nil {
 		return fmt.Errorf("init LDAP connection failed: %v", err)
 	}
 
 	// Global variable assignment
 	manager.ldapool = &ldapConnPool{
-		conns:    make([]*ldap.Conn, 0),
-		reqConns: make(map[uint64]chan *ldap.Conn),
-		openConn: 0,
+		conns:    make([]*pooledConn, 0),
+		meta:     make(map[ldap.Client]*pooledConn),
+		reqConns: make(map[uint64]chan connResult),
 		maxOpen:  manager.config.MaxOpen,
 		config:   manager.config,
+		stopCh:   make(chan struct{}),
+	}
+	manager.ldapool.seedIdle(ldapConn)
+
+	if manager.config.HealthCheckInterval > 0 {
+		go manager.ldapool.healthCheckLoop()
 	}
-	manager.PutConn(ldapConn)
 	return nil
 }
 
 // Close all connections in the pool
 func (manager *LdapPoolManager) Close() {
+	manager.ldapool.stopOnce.Do(func() { close(manager.ldapool.stopCh) })
+
 	manager.ldapool.mu.Lock()
 	defer manager.ldapool.mu.Unlock()
 
-	for _, conn := range manager.ldapool.conns {
-		conn.Close()
+	for _, pc := range manager.ldapool.conns {
+		pc.conn.Close()
 	}
 	manager.ldapool.conns = nil
+	manager.ldapool.meta = nil
 	manager.ldapool.reqConns = nil
 	manager.closed = true
 }
@@ -104,73 +228,477 @@ func (manager *LdapPoolManager) IsClosed() bool {
 }
 
 // GetConn Get LDAP connection
-func (manager *LdapPoolManager) GetConn() (*ldap.Conn, error) {
+func (manager *LdapPoolManager) GetConn() (ldap.Client, error) {
 	return manager.ldapool.getConnection()
 }
 
+// GetConnCtx is the context-aware variant of GetConn: a waiter parked behind
+// MaxOpen also unblocks when ctx is done or LdapConfig.WaitTimeout elapses,
+// the way database/sql bounds pool acquisition.
+func (manager *LdapPoolManager) GetConnCtx(ctx context.Context) (ldap.Client, error) {
+	return manager.ldapool.getConnectionCtx(ctx)
+}
+
 // PutConn Put back the LDAP connection
-func (manager *LdapPoolManager) PutConn(conn *ldap.Conn) {
-	manager.ldapool.putConnection(conn)
+func (manager *LdapPoolManager) PutConn(conn ldap.Client) {
+	manager.ldapool.putConnection(conn, 0)
+}
+
+// PutConnErr puts back the LDAP connection, reporting the result code of the
+// last operation performed on it. If err is an *ldap.Error whose result code
+// is one of LdapConfig.CloseOnResultCodes the connection is closed instead of
+// reused.
+func (manager *LdapPoolManager) PutConnErr(conn ldap.Client, err error) {
+	manager.ldapool.putConnection(conn, resultCodeOf(err))
+}
+
+// resultCodeOf extracts the LDAP result code from an error, if any.
+func resultCodeOf(err error) uint16 {
+	if ldapErr, ok := err.(*ldap.Error); ok {
+		return ldapErr.ResultCode
+	}
+	return 0
+}
+
+// Stats returns a snapshot of pool activity.
+func (manager *LdapPoolManager) Stats() PoolStats {
+	return manager.ldapool.stats()
+}
+
+func (lcp *ldapConnPool) stats() PoolStats {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+
+	return PoolStats{
+		MaxOpen:      lcp.maxOpen,
+		InUse:        lcp.inUse,
+		Idle:         len(lcp.conns),
+		WaitCount:    lcp.waitCount,
+		WaitDuration: lcp.waitDuration,
+		Timeouts:     lcp.timeouts,
+		Reconnects:   lcp.reconnects,
+	}
+}
+
+// notifyAcquire reports conn to the configured Observer, if any, when it's
+// handed out to a caller. A nil conn (a failed dial) is not reported.
+func (lcp *ldapConnPool) notifyAcquire(conn ldap.Client) {
+	if conn != nil && lcp.config.Observer != nil {
+		lcp.config.Observer.OnAcquire(conn)
+	}
+}
+
+// notifyRelease reports conn to the configured Observer, if any, when a
+// caller returns it to the pool.
+func (lcp *ldapConnPool) notifyRelease(conn ldap.Client) {
+	if lcp.config.Observer != nil {
+		lcp.config.Observer.OnRelease(conn)
+	}
+}
+
+// Do acquires a connection from the pool, invokes fn, and always returns the
+// connection to the pool — closing it instead if fn reports a network-class
+// error. This is the pattern to reach for instead of pairing GetConn/PutConn
+// by hand, which leaks connections whenever a caller forgets to Put on the
+// error path.
+func (manager *LdapPoolManager) Do(ctx context.Context, fn func(ldap.Client) error) error {
+	conn, err := manager.GetConnCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(conn)
+	if isNetworkError(err) {
+		manager.ldapool.discardConn(conn)
+		return err
+	}
+
+	manager.PutConnErr(conn, err)
+	return err
+}
+
+// Search runs req against a pooled connection via Do.
+func (manager *LdapPoolManager) Search(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := manager.Do(ctx, func(conn ldap.Client) error {
+		var err error
+		result, err = conn.Search(req)
+		return err
+	})
+	return result, err
+}
+
+// Bind authenticates as username/password on a pooled connection via Do.
+func (manager *LdapPoolManager) Bind(ctx context.Context, username, password string) error {
+	return manager.Do(ctx, func(conn ldap.Client) error {
+		return conn.Bind(username, password)
+	})
+}
+
+// Modify runs req against a pooled connection via Do.
+func (manager *LdapPoolManager) Modify(ctx context.Context, req *ldap.ModifyRequest) error {
+	return manager.Do(ctx, func(conn ldap.Client) error {
+		return conn.Modify(req)
+	})
+}
+
+// isNetworkError reports whether err indicates the underlying connection is
+// no longer usable, as opposed to an application-level LDAP error (e.g. no
+// such object, constraint violation) where the connection is still healthy.
+func isNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if ldapErr, ok := err.(*ldap.Error); ok {
+		return ldapErr.ResultCode == ldap.ErrorNetwork
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 // getConnection
-func (lcp *ldapConnPool) getConnection() (*ldap.Conn, error) {
+func (lcp *ldapConnPool) getConnection() (ldap.Client, error) {
 	lcp.mu.Lock()
 	// Determine whether there is a connection in the current connection pool
 	connNum := len(lcp.conns)
 	if connNum > 0 {
-		lcp.openConn++
-		conn := lcp.conns[0]
+		lcp.inUse++
+		pc := lcp.conns[0]
 		copy(lcp.conns, lcp.conns[1:])
 		lcp.conns = lcp.conns[:connNum-1]
 
-		lcp.mu.Unlock()
-		// If the connection has been closed, get the connection again
-		if conn.IsClosing() {
-			return initLDAPConn(lcp.config)
+		// If the connection has been closed, or has exceeded its max lifetime,
+		// dial a new one instead of handing out a stale one.
+		if pc.conn.IsClosing() || lcp.expired(pc) {
+			delete(lcp.meta, pc.conn)
+			lcp.reconnects++
+			lcp.mu.Unlock()
+			pc.conn.Close()
+			conn, err := lcp.dialForAcquire()
+			lcp.notifyAcquire(conn)
+			return conn, err
 		}
-		return conn, nil
+		lcp.mu.Unlock()
+		lcp.notifyAcquire(pc.conn)
+		return pc.conn, nil
 	}
 
 	// When the existing connection pool is empty and the maximum connection limit is currently exceeded
-	if lcp.maxOpen != 0 && lcp.openConn > lcp.maxOpen {
+	if lcp.maxOpen != 0 && lcp.inUse >= lcp.maxOpen {
 		// Create a waiting queue
-		req := make(chan *ldap.Conn, 1)
+		lcp.waitCount++
+		waitStart := time.Now()
+		req := make(chan connResult, 1)
 		reqKey := lcp.nextRequestKeyLocked()
 		lcp.reqConns[reqKey] = req
 		lcp.mu.Unlock()
 
+		var timeout <-chan time.Time
+		if lcp.config.WaitTimeout > 0 {
+			timer := time.NewTimer(lcp.config.WaitTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
 		// Waiting for request for return
-		return <-req, nil
+		select {
+		case res := <-req:
+			lcp.mu.Lock()
+			lcp.waitDuration += time.Since(waitStart)
+			lcp.mu.Unlock()
+			lcp.notifyAcquire(res.conn)
+			return res.conn, res.err
+		case <-timeout:
+			lcp.abandonRequest(reqKey, req, waitStart)
+			return nil, fmt.Errorf("ldapool: timed out waiting for a connection")
+		}
 	} else {
-		lcp.openConn++
+		lcp.inUse++
 		lcp.mu.Unlock()
-		return initLDAPConn(lcp.config)
+		conn, err := lcp.dialForAcquire()
+		lcp.notifyAcquire(conn)
+		return conn, err
 	}
 }
 
-func (lcp *ldapConnPool) putConnection(conn *ldap.Conn) {
+// dialForAcquire dials a new connection for a caller that has already
+// incremented inUse to reserve its slot. If the dial fails there is no
+// connection for the caller to PutConn back, so the reservation must be
+// given back here — otherwise inUse leaks by one on every failed dial and
+// the pool eventually wedges behind MaxOpen despite having no connections
+// actually checked out.
+func (lcp *ldapConnPool) dialForAcquire() (ldap.Client, error) {
+	conn, err := dial(lcp.config)
+	if err != nil {
+		lcp.mu.Lock()
+		lcp.inUse--
+		lcp.mu.Unlock()
+	}
+	return conn, err
+}
+
+// getConnectionCtx is the context-aware variant of getConnection: a waiter
+// parked on the request channel also selects on ctx.Done() and on
+// LdapConfig.WaitTimeout, instead of blocking forever if no connection is
+// ever returned.
+func (lcp *ldapConnPool) getConnectionCtx(ctx context.Context) (ldap.Client, error) {
+	lcp.mu.Lock()
+	connNum := len(lcp.conns)
+	if connNum > 0 {
+		lcp.inUse++
+		pc := lcp.conns[0]
+		copy(lcp.conns, lcp.conns[1:])
+		lcp.conns = lcp.conns[:connNum-1]
+
+		if pc.conn.IsClosing() || lcp.expired(pc) {
+			delete(lcp.meta, pc.conn)
+			lcp.reconnects++
+			lcp.mu.Unlock()
+			pc.conn.Close()
+			conn, err := lcp.dialForAcquire()
+			lcp.notifyAcquire(conn)
+			return conn, err
+		}
+		lcp.mu.Unlock()
+		lcp.notifyAcquire(pc.conn)
+		return pc.conn, nil
+	}
+
+	if lcp.maxOpen != 0 && lcp.inUse >= lcp.maxOpen {
+		lcp.waitCount++
+		waitStart := time.Now()
+		req := make(chan connResult, 1)
+		reqKey := lcp.nextRequestKeyLocked()
+		lcp.reqConns[reqKey] = req
+		lcp.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if lcp.config.WaitTimeout > 0 {
+			timer := time.NewTimer(lcp.config.WaitTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case res := <-req:
+			lcp.mu.Lock()
+			lcp.waitDuration += time.Since(waitStart)
+			lcp.mu.Unlock()
+			lcp.notifyAcquire(res.conn)
+			return res.conn, res.err
+		case <-ctx.Done():
+			lcp.abandonRequest(reqKey, req, waitStart)
+			return nil, ctx.Err()
+		case <-timeout:
+			lcp.abandonRequest(reqKey, req, waitStart)
+			return nil, fmt.Errorf("ldapool: timed out waiting for a connection")
+		}
+	}
+
+	lcp.inUse++
+	lcp.mu.Unlock()
+	conn, err := lcp.dialForAcquire()
+	lcp.notifyAcquire(conn)
+	return conn, err
+}
+
+// abandonRequest removes reqKey from the waiting queue and gives back the
+// inUse slot it was reserving, used when a waiter gives up on ctx
+// cancellation or WaitTimeout instead of receiving a connection.
+//
+// putConnection can race this: it may already have deleted reqKey and
+// committed a connection to req before abandonRequest takes the lock, in
+// which case Go's select in getConnectionCtx can still pick the ctx/timeout
+// case. reqKey being gone from the map means that send is guaranteed (it's
+// the only consumer of this reqKey), so draining req here is safe and
+// necessary — otherwise the connection sits in the orphaned channel forever,
+// its socket never closed and its inUse slot never freed.
+func (lcp *ldapConnPool) abandonRequest(reqKey uint64, req chan connResult, waitStart time.Time) {
+	lcp.mu.Lock()
+	lcp.waitDuration += time.Since(waitStart)
+	lcp.timeouts++
+	if _, ok := lcp.reqConns[reqKey]; ok {
+		delete(lcp.reqConns, reqKey)
+		lcp.inUse--
+		lcp.mu.Unlock()
+		return
+	}
+	lcp.mu.Unlock()
+
+	res := <-req
+	if res.conn != nil {
+		lcp.putConnection(res.conn, 0)
+		return
+	}
+	lcp.mu.Lock()
+	lcp.inUse--
+	lcp.mu.Unlock()
+}
+
+// expired reports whether pc has outlived LdapConfig.MaxLifetime.
+func (lcp *ldapConnPool) expired(pc *pooledConn) bool {
+	return lcp.config.MaxLifetime > 0 && time.Since(pc.createdAt) > lcp.config.MaxLifetime
+}
+
+// unhealthy reports whether resultCode is one of LdapConfig.CloseOnResultCodes.
+func (lcp *ldapConnPool) unhealthy(resultCode uint16) bool {
+	if resultCode == 0 {
+		return false
+	}
+	for _, code := range lcp.config.CloseOnResultCodes {
+		if code == resultCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (lcp *ldapConnPool) putConnection(conn ldap.Client, resultCode uint16) {
+	lcp.releaseConn(conn, resultCode, false)
+}
+
+// seedIdle adds conn to the idle pool directly, for the initial connection
+// dialed in initLDAP. Unlike putConnection, it doesn't touch inUse: that
+// connection was never checked out through getConnection in the first
+// place, so treating its arrival as a "return" would decrement inUse past
+// what any caller ever incremented, corrupting Stats() from the very first
+// GetConn.
+func (lcp *ldapConnPool) seedIdle(conn ldap.Client) {
 	lcp.mu.Lock()
 	defer lcp.mu.Unlock()
 
+	pc := &pooledConn{conn: conn, createdAt: time.Now(), lastUsed: time.Now()}
+	lcp.meta[conn] = pc
+	lcp.conns = append(lcp.conns, pc)
+}
+
+// discardConn returns conn's inUse slot to the pool without reusing the
+// connection itself — handing a freshly dialed replacement to a waiter if
+// one is parked, same as an unhealthy putConnection. Callers use this when
+// they already know the connection is unusable (e.g. Do() saw a
+// network-class error) instead of going through the CloseOnResultCodes
+// check with a synthetic code.
+func (lcp *ldapConnPool) discardConn(conn ldap.Client) {
+	lcp.releaseConn(conn, 0, true)
+}
+
+func (lcp *ldapConnPool) releaseConn(conn ldap.Client, resultCode uint16, forceDiscard bool) {
+	lcp.notifyRelease(conn)
+
+	lcp.mu.Lock()
+
+	pc, ok := lcp.meta[conn]
+	if !ok {
+		pc = &pooledConn{conn: conn, createdAt: time.Now()}
+		lcp.meta[conn] = pc
+	}
+	pc.lastUsed = time.Now()
+	pc.lastResultCode = resultCode
+	unhealthy := forceDiscard || conn.IsClosing() || lcp.unhealthy(resultCode) || lcp.expired(pc)
+
 	// First determine whether there is a waiting queue
 	if num := len(lcp.reqConns); num > 0 {
-		var req chan *ldap.Conn
+		var req chan connResult
 		var reqKey uint64
 		for reqKey, req = range lcp.reqConns {
 			break
 		}
 		delete(lcp.reqConns, reqKey)
-		req <- conn
+
+		if !unhealthy {
+			lcp.mu.Unlock()
+			req <- connResult{conn: conn}
+			return
+		}
+
+		// The connection being returned isn't fit to hand to the waiter —
+		// the same liveness/retry-code checks applied to the idle pool must
+		// apply here too. Close it and dial a fresh one in its place.
+		delete(lcp.meta, conn)
+		lcp.reconnects++
+		lcp.mu.Unlock()
+		conn.Close()
+		fresh, err := dial(lcp.config)
+		req <- connResult{conn: fresh, err: err}
 		return
-	} else {
-		lcp.openConn--
-		if !conn.IsClosing() {
-			lcp.conns = append(lcp.conns, conn)
+	}
+
+	lcp.inUse--
+	if unhealthy {
+		delete(lcp.meta, conn)
+		lcp.mu.Unlock()
+		conn.Close()
+		return
+	}
+	lcp.conns = append(lcp.conns, pc)
+	lcp.mu.Unlock()
+}
+
+// healthCheckLoop periodically sweeps idle connections that have sat unused
+// longer than HealthCheckInterval, evicting any that fail a cheap WhoAmI
+// probe. It runs for the lifetime of the pool and exits when the pool closes.
+func (lcp *ldapConnPool) healthCheckLoop() {
+	ticker := time.NewTicker(lcp.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lcp.stopCh:
+			return
+		case <-ticker.C:
+			lcp.sweepIdleConns()
 		}
 	}
 }
 
+// sweepIdleConns checks every idle connection that has been sitting longer
+// than HealthCheckInterval and evicts any that fail the liveness probe or
+// have exceeded MaxLifetime. The probes run with the pool locked, trading
+// throughput for simplicity: the pool is small and idle by definition here.
+func (lcp *ldapConnPool) sweepIdleConns() {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+
+	fresh := lcp.conns[:0]
+	for _, pc := range lcp.conns {
+		due := lcp.expired(pc) || time.Since(pc.lastUsed) > lcp.config.HealthCheckInterval
+		if due && (lcp.expired(pc) || !isAlive(pc.conn)) {
+			// pc is idle, not checked out, so evicting it shrinks the pool
+			// without touching inUse.
+			delete(lcp.meta, pc.conn)
+			pc.conn.Close()
+			continue
+		}
+		if due {
+			pc.lastUsed = time.Now()
+		}
+		fresh = append(fresh, pc)
+	}
+	lcp.conns = fresh
+}
+
+// whoAmIer is implemented by *ldap.Conn; probed via a type assertion since
+// it isn't part of the ldap.Client interface, so fake clients injected
+// through PoolFactory in tests don't need to implement it.
+type whoAmIer interface {
+	WhoAmI(controls []ldap.Control) (*ldap.WhoAmIResult, error)
+}
+
+// isAlive issues a cheap WhoAmI request to confirm the server hasn't silently
+// reset the socket — IsClosing() alone can't detect that. Clients that don't
+// support WhoAmI are assumed alive as long as they aren't closing.
+func isAlive(conn ldap.Client) bool {
+	if conn.IsClosing() {
+		return false
+	}
+	w, ok := conn.(whoAmIer)
+	if !ok {
+		return true
+	}
+	_, err := w.WhoAmI(nil)
+	return err == nil
+}
+
 // nextRequestKeyLocked Get the next request token
 func (lcp *ldapConnPool) nextRequestKeyLocked() uint64 {
 	for {
@@ -181,11 +709,112 @@ func (lcp *ldapConnPool) nextRequestKeyLocked() uint64 {
 	}
 }
 
-// initLDAPConn
-func initLDAPConn(conf LdapConfig) (*ldap.Conn, error) {
-	ldap, err := ldap.DialURL(conf.Url, ldap.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}))
+// dial creates a new connection for the pool via conf.PoolFactory, falling
+// back to initLDAPConn when none is configured, and reports the attempt to
+// conf.Observer.
+func dial(conf LdapConfig) (ldap.Client, error) {
+	var conn ldap.Client
+	var err error
+	if conf.PoolFactory != nil {
+		conn, err = conf.PoolFactory(conf)
+	} else {
+		conn, err = initLDAPConn(conf)
+	}
+
+	if conf.Observer != nil {
+		conf.Observer.OnDial(conn, err)
+	}
+	return conn, err
+}
+
+// initLDAPConn dials a fresh connection and brings it up to the state the
+// pool promises callers: optionally over TLS, optionally upgraded with
+// StartTLS, and bound as BindDN if configured. It is the default PoolFactory:
+// when LdapConfig.PoolFactory is nil, every re-dial (including the one
+// triggered by IsClosing() in getConnection) goes through here so the
+// bound/TLS state is restored transparently.
+func initLDAPConn(conf LdapConfig) (ldap.Client, error) {
+	dialOpts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second})}
+	serverName := serverNameFromURL(conf.Url)
+
+	if conf.UseTLS {
+		tlsConfig, err := buildTLSConfig(conf.TLSConfig, serverName)
+		if err != nil {
+			return nil, fmt.Errorf("build TLS config failed: %v", err)
+		}
+		dialOpts = append(dialOpts, ldap.DialWithTLSConfig(tlsConfig))
+	}
+
+	conn, err := ldap.DialURL(conf.Url, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
-	return ldap, err
+
+	if conf.StartTLS {
+		tlsConfig, err := buildTLSConfig(conf.TLSConfig, serverName)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("build TLS config failed: %v", err)
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %v", err)
+		}
+	}
+
+	if conf.BindDN != "" {
+		if err := conn.Bind(conf.BindDN, conf.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("bind failed: %v", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// serverNameFromURL extracts the host (sans port) from an LDAP URL for use as
+// tls.Config.ServerName. ldap.DialWithTLSConfig fills this in automatically
+// for the UseTLS path, but ldap.Conn.StartTLS hands the config straight to
+// tls.Client with no such auto-fill, so it must be set explicitly here.
+func serverNameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if host := u.Hostname(); host != "" {
+		return host
+	}
+	return ""
+}
+
+// buildTLSConfig turns a TLSConfig into a *tls.Config, loading the CA and
+// client certificate files when configured. serverName is used to verify the
+// server's certificate when SkipCertVerification is false.
+func buildTLSConfig(cfg TLSConfig, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.SkipCertVerification,
+		ServerName:         serverName,
+	}
+
+	if cfg.CaFile != "" {
+		caCert, err := os.ReadFile(cfg.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file failed: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA file %s", cfg.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
 }
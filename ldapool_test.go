@@ -1,9 +1,21 @@
 package ldapool
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 )
@@ -74,3 +86,488 @@ func TestLdapPoolManager(t *testing.T) {
 		t.Fatalf("Manager should be closed")
 	}
 }
+
+// TestLdapPoolManagerWithFactory exercises the pool with a fake ldap.Client
+// injected via PoolFactory, so it runs without a real LDAP server.
+func TestLdapPoolManagerWithFactory(t *testing.T) {
+	var dials int32
+
+	config := LdapConfig{
+		Url:     "ldap://unused:389",
+		BaseDN:  "dc=example,dc=com",
+		MaxOpen: 2,
+		PoolFactory: func(cfg LdapConfig) (ldap.Client, error) {
+			atomic.AddInt32(&dials, 1)
+			return &fakeLdapClient{}, nil
+		},
+	}
+
+	manager, err := NewLdapPoolManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create LdapPoolManager: %v", err)
+	}
+
+	conn, err := manager.Open()
+	if err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+	manager.PutConn(conn)
+
+	if atomic.LoadInt32(&dials) == 0 {
+		t.Fatalf("expected PoolFactory to be used instead of a real LDAP dial")
+	}
+
+	manager.Close()
+
+	if !manager.IsClosed() {
+		t.Fatalf("Manager should be closed")
+	}
+}
+
+// TestGetConnDialFailureDoesNotLeakInUse checks that a failed dial in the
+// no-waiter acquire path gives back the inUse slot it reserved. Without
+// this, a transient dial error permanently shrinks the pool's usable
+// capacity until it eventually wedges every GetConn behind MaxOpen.
+func TestGetConnDialFailureDoesNotLeakInUse(t *testing.T) {
+	var calls int32
+	config := LdapConfig{
+		Url:     "ldap://unused:389",
+		BaseDN:  "dc=example,dc=com",
+		MaxOpen: 2,
+		PoolFactory: func(cfg LdapConfig) (ldap.Client, error) {
+			if atomic.AddInt32(&calls, 1) == 2 {
+				return nil, fmt.Errorf("simulated dial failure")
+			}
+			return &fakeLdapClient{}, nil
+		},
+	}
+
+	manager, err := NewLdapPoolManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create LdapPoolManager: %v", err)
+	}
+	defer manager.Close()
+
+	conn1, err := manager.Open()
+	if err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+
+	if _, err := manager.Open(); err == nil {
+		t.Fatalf("expected the simulated dial failure to surface as an error")
+	}
+
+	if stats := manager.Stats(); stats.InUse != 1 {
+		t.Fatalf("expected InUse=1 after the failed dial gave back its reservation, got %d", stats.InUse)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		conn3, err := manager.Open()
+		if err == nil {
+			manager.PutConn(conn3)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("third Open failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("third Open blocked — the failed dial leaked an inUse slot")
+	}
+
+	manager.PutConn(conn1)
+}
+
+// TestAbandonRequestDrainsLateConn checks the race where putConnection
+// commits a connection to a waiter (deleting its reqKey) right as the
+// waiter gives up on ctx/WaitTimeout — abandonRequest must drain and
+// release that connection instead of leaving it orphaned in the channel.
+func TestAbandonRequestDrainsLateConn(t *testing.T) {
+	lcp := &ldapConnPool{
+		reqConns: make(map[uint64]chan connResult),
+		meta:     make(map[ldap.Client]*pooledConn),
+		maxOpen:  1,
+		inUse:    1,
+	}
+
+	reqKey := uint64(1)
+	req := make(chan connResult, 1)
+	lcp.reqConns[reqKey] = req
+
+	// Simulate putConnection winning the race: it deletes reqKey and sends
+	// the connection before abandonRequest takes the lock.
+	delete(lcp.reqConns, reqKey)
+	conn := &fakeLdapClient{}
+	req <- connResult{conn: conn}
+
+	lcp.abandonRequest(reqKey, req, time.Now())
+
+	if lcp.inUse != 0 {
+		t.Fatalf("expected inUse to drop back to 0 after the abandoned waiter's connection was drained, got %d", lcp.inUse)
+	}
+	if len(lcp.conns) != 1 || lcp.conns[0].conn != conn {
+		t.Fatalf("expected the late-arriving connection to be returned to the idle pool instead of orphaned")
+	}
+}
+
+// TestDoDiscardsConnOnNetworkError checks that Do() gives back the inUse
+// slot of a connection it discards on a network-class error, instead of
+// leaking it the way a bare conn.Close() would.
+func TestDoDiscardsConnOnNetworkError(t *testing.T) {
+	config := LdapConfig{
+		Url:     "ldap://unused:389",
+		BaseDN:  "dc=example,dc=com",
+		MaxOpen: 1,
+		PoolFactory: func(cfg LdapConfig) (ldap.Client, error) {
+			return &fakeLdapClient{}, nil
+		},
+	}
+
+	manager, err := NewLdapPoolManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create LdapPoolManager: %v", err)
+	}
+	defer manager.Close()
+
+	err = manager.Do(context.Background(), func(conn ldap.Client) error {
+		return &ldap.Error{ResultCode: ldap.ErrorNetwork}
+	})
+	if err == nil {
+		t.Fatalf("expected Do to return the network error")
+	}
+
+	if stats := manager.Stats(); stats.InUse != 0 {
+		t.Fatalf("expected InUse=0 after Do discarded a network-broken connection, got %d", stats.InUse)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Do(context.Background(), func(conn ldap.Client) error { return nil })
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("second Do failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("second Do blocked — the connection slot leaked by the first Do's network error")
+	}
+}
+
+// TestLdapPoolManagerPutUnhealthyWithWaiter checks that a connection handed
+// back with a CloseOnResultCodes code is closed and replaced rather than
+// passed straight through to a waiter parked behind MaxOpen, which would
+// bypass the liveness/retry-code checks entirely.
+func TestLdapPoolManagerPutUnhealthyWithWaiter(t *testing.T) {
+	config := LdapConfig{
+		Url:                "ldap://unused:389",
+		BaseDN:             "dc=example,dc=com",
+		MaxOpen:            1,
+		CloseOnResultCodes: []uint16{ldap.LDAPResultTimeLimitExceeded},
+		PoolFactory: func(cfg LdapConfig) (ldap.Client, error) {
+			return &fakeLdapClient{}, nil
+		},
+	}
+
+	manager, err := NewLdapPoolManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create LdapPoolManager: %v", err)
+	}
+	defer manager.Close()
+
+	conn1, err := manager.Open()
+	if err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+
+	waiterDone := make(chan ldap.Client, 1)
+	go func() {
+		conn, _ := manager.Open()
+		waiterDone <- conn
+	}()
+
+	for i := 0; i < 1000 && manager.Stats().WaitCount == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if manager.Stats().WaitCount == 0 {
+		t.Fatalf("waiter never parked behind MaxOpen")
+	}
+
+	manager.PutConnErr(conn1, &ldap.Error{ResultCode: ldap.LDAPResultTimeLimitExceeded})
+
+	conn2 := <-waiterDone
+	if conn2 == conn1 {
+		t.Fatalf("expected the waiter to receive a freshly dialed connection instead of the unhealthy one")
+	}
+	if !conn1.(*fakeLdapClient).closed {
+		t.Fatalf("expected the unhealthy connection to be closed instead of handed to the waiter")
+	}
+}
+
+// TestHealthCheckLoopEvictsDeadIdleConn checks that the background
+// healthCheckLoop started for a non-zero HealthCheckInterval actually
+// evicts an idle connection that fails its WhoAmI liveness probe, rather
+// than leaving a silently-reset connection sitting in the idle pool.
+func TestHealthCheckLoopEvictsDeadIdleConn(t *testing.T) {
+	config := LdapConfig{
+		Url:                 "ldap://unused:389",
+		BaseDN:              "dc=example,dc=com",
+		MaxOpen:             1,
+		HealthCheckInterval: 5 * time.Millisecond,
+		PoolFactory: func(cfg LdapConfig) (ldap.Client, error) {
+			return &fakeLdapClient{}, nil
+		},
+	}
+
+	manager, err := NewLdapPoolManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create LdapPoolManager: %v", err)
+	}
+	defer manager.Close()
+
+	conn, err := manager.Open()
+	if err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+	fake := conn.(*fakeLdapClient)
+	fake.whoAmIErr = fmt.Errorf("simulated: server reset the socket")
+	manager.PutConn(conn)
+
+	for i := 0; i < 1000 && manager.Stats().Idle != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats := manager.Stats(); stats.Idle != 0 {
+		t.Fatalf("expected healthCheckLoop to evict the dead idle connection, got Idle=%d", stats.Idle)
+	}
+	if !fake.closed {
+		t.Fatalf("expected the dead connection to be closed by the health check loop")
+	}
+}
+
+// TestSweepIdleConnsEvictsExpiredLifetime checks the MaxLifetime branch of
+// sweepIdleConns directly: an idle connection past MaxLifetime is evicted
+// without needing a WhoAmI probe at all.
+func TestSweepIdleConnsEvictsExpiredLifetime(t *testing.T) {
+	lcp := &ldapConnPool{
+		meta:   make(map[ldap.Client]*pooledConn),
+		config: LdapConfig{MaxLifetime: time.Millisecond},
+	}
+	conn := &fakeLdapClient{}
+	pc := &pooledConn{conn: conn, createdAt: time.Now().Add(-time.Hour), lastUsed: time.Now()}
+	lcp.conns = []*pooledConn{pc}
+	lcp.meta[conn] = pc
+
+	lcp.sweepIdleConns()
+
+	if len(lcp.conns) != 0 {
+		t.Fatalf("expected the expired connection to be evicted, got %d idle conns", len(lcp.conns))
+	}
+	if !conn.closed {
+		t.Fatalf("expected the expired connection to be closed")
+	}
+	if _, ok := lcp.meta[conn]; ok {
+		t.Fatalf("expected the evicted connection to be removed from meta")
+	}
+}
+
+// fakeLdapClient implements ldap.Client by embedding the (nil) interface and
+// overriding only the methods the pool itself calls.
+type fakeLdapClient struct {
+	ldap.Client
+	closed    bool
+	whoAmIErr error
+}
+
+func (f *fakeLdapClient) IsClosing() bool { return f.closed }
+func (f *fakeLdapClient) Close() error    { f.closed = true; return nil }
+
+// WhoAmI lets tests simulate a server that has silently reset the socket:
+// isAlive() probes it via this method on any client that implements it.
+func (f *fakeLdapClient) WhoAmI(controls []ldap.Control) (*ldap.WhoAmIResult, error) {
+	if f.whoAmIErr != nil {
+		return nil, f.whoAmIErr
+	}
+	return &ldap.WhoAmIResult{}, nil
+}
+
+// TestLdapPoolManagerStats checks that InUse/Idle track checked-out
+// connections correctly and that MaxOpen is never exceeded, guarding
+// against the off-by-one gate and the double-counted openConn that used to
+// let the pool dial past MaxOpen.
+func TestLdapPoolManagerStats(t *testing.T) {
+	config := LdapConfig{
+		Url:     "ldap://unused:389",
+		BaseDN:  "dc=example,dc=com",
+		MaxOpen: 1,
+		PoolFactory: func(cfg LdapConfig) (ldap.Client, error) {
+			return &fakeLdapClient{}, nil
+		},
+	}
+
+	manager, err := NewLdapPoolManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create LdapPoolManager: %v", err)
+	}
+	defer manager.Close()
+
+	conn, err := manager.Open()
+	if err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+
+	stats := manager.Stats()
+	if stats.InUse != 1 {
+		t.Fatalf("expected InUse=1 while a connection is checked out, got %d", stats.InUse)
+	}
+	if stats.InUse > stats.MaxOpen {
+		t.Fatalf("InUse (%d) must never exceed MaxOpen (%d)", stats.InUse, stats.MaxOpen)
+	}
+
+	manager.PutConn(conn)
+
+	stats = manager.Stats()
+	if stats.InUse != 0 || stats.Idle != 1 {
+		t.Fatalf("expected InUse=0, Idle=1 after PutConn, got InUse=%d Idle=%d", stats.InUse, stats.Idle)
+	}
+}
+
+// TestServerNameFromURL checks that the TLS ServerName is derived from the
+// LDAP URL's host, stripping any port, and that a malformed URL degrades to
+// an empty ServerName rather than an error.
+func TestServerNameFromURL(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"ldap://example.com:389", "example.com"},
+		{"ldaps://example.com", "example.com"},
+		{"ldap://127.0.0.1:389", "127.0.0.1"},
+		{"ldapi:///var/run/ldapi", ""},
+		{"://not-a-url", ""},
+	}
+
+	for _, c := range cases {
+		if got := serverNameFromURL(c.url); got != c.want {
+			t.Errorf("serverNameFromURL(%q) = %q, want %q", c.url, got, c.want)
+		}
+	}
+}
+
+// TestBuildTLSConfigDefaults checks that SkipCertVerification and the
+// derived ServerName land in the resulting tls.Config with no CA or client
+// certificate configured.
+func TestBuildTLSConfigDefaults(t *testing.T) {
+	tlsConfig, err := buildTLSConfig(TLSConfig{SkipCertVerification: true}, "example.com")
+	if err != nil {
+		t.Fatalf("buildTLSConfig failed: %v", err)
+	}
+	if !tlsConfig.InsecureSkipVerify {
+		t.Fatalf("expected InsecureSkipVerify to be true")
+	}
+	if tlsConfig.ServerName != "example.com" {
+		t.Fatalf("expected ServerName %q, got %q", "example.com", tlsConfig.ServerName)
+	}
+	if tlsConfig.RootCAs != nil {
+		t.Fatalf("expected no RootCAs without a CaFile")
+	}
+	if len(tlsConfig.Certificates) != 0 {
+		t.Fatalf("expected no client certificate without CertFile/KeyFile")
+	}
+}
+
+// TestBuildTLSConfigCAFile checks that a valid CA file is loaded into
+// RootCAs, and that an unreadable or unparsable one surfaces as an error.
+func TestBuildTLSConfigCAFile(t *testing.T) {
+	certPEM, _ := generateTestCert(t)
+	dir := t.TempDir()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	tlsConfig, err := buildTLSConfig(TLSConfig{CaFile: caFile}, "example.com")
+	if err != nil {
+		t.Fatalf("buildTLSConfig failed: %v", err)
+	}
+	if tlsConfig.RootCAs == nil {
+		t.Fatalf("expected RootCAs to be populated from CaFile")
+	}
+
+	if _, err := buildTLSConfig(TLSConfig{CaFile: filepath.Join(dir, "missing.pem")}, ""); err == nil {
+		t.Fatalf("expected an error for a CaFile that doesn't exist")
+	}
+
+	garbageFile := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbageFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write garbage CA file: %v", err)
+	}
+	if _, err := buildTLSConfig(TLSConfig{CaFile: garbageFile}, ""); err == nil {
+		t.Fatalf("expected an error for a CaFile that doesn't parse as PEM")
+	}
+}
+
+// TestBuildTLSConfigClientCert checks that a valid cert/key pair is loaded
+// into Certificates, and that a mismatched key surfaces as an error.
+func TestBuildTLSConfigClientCert(t *testing.T) {
+	certPEM, keyPEM := generateTestCert(t)
+	_, otherKeyPEM := generateTestCert(t)
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	otherKeyFile := filepath.Join(dir, "other-key.pem")
+	for path, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM, otherKeyFile: otherKeyPEM} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	tlsConfig, err := buildTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile}, "")
+	if err != nil {
+		t.Fatalf("buildTLSConfig failed: %v", err)
+	}
+	if len(tlsConfig.Certificates) != 1 {
+		t.Fatalf("expected one client certificate to be loaded, got %d", len(tlsConfig.Certificates))
+	}
+
+	if _, err := buildTLSConfig(TLSConfig{CertFile: certFile, KeyFile: otherKeyFile}, ""); err == nil {
+		t.Fatalf("expected an error when the key doesn't match the certificate")
+	}
+}
+
+// generateTestCert returns a self-signed certificate and its private key,
+// both PEM-encoded, for use as fixtures in the buildTLSConfig tests above.
+func generateTestCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldapool-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal test key: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}